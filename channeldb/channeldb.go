@@ -0,0 +1,39 @@
+package channeldb
+
+import (
+	"encoding/binary"
+
+	"github.com/boltdb/bolt"
+)
+
+// byteOrder is the preferred byte order used through the database, for
+// database compatibility purposes.
+var byteOrder = binary.BigEndian
+
+// DB is the primary datastore for the lnd daemon. It wraps a bolt.DB
+// instance and provides higher level accessors for the various
+// sub-systems (channel state, invoices, outgoing payments, etc.) that
+// persist data on top of it.
+type DB struct {
+	*bolt.DB
+	dbPath string
+}
+
+// Open opens the channeldb stored in the file at dbPath. If that file does
+// not exist yet, a new, empty database is created there.
+func Open(dbPath string) (*DB, error) {
+	bdb, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{
+		DB:     bdb,
+		dbPath: dbPath,
+	}, nil
+}
+
+// Path returns the file path to the channel database.
+func (d *DB) Path() string {
+	return d.dbPath
+}
@@ -0,0 +1,38 @@
+package channeldb
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// rev is a shared preimage used by the various test helpers in this
+// package to derive deterministic payment hashes.
+var rev [32]byte
+
+func init() {
+	for i := range rev {
+		rev[i] = byte(i)
+	}
+}
+
+// makeTestDB creates a new instance of the ChannelDB for testing purposes,
+// backed by a temporary directory on disk. A callback which cleans up the
+// created temporary directory is also returned.
+func makeTestDB() (*DB, func(), error) {
+	tempDirName, err := ioutil.TempDir("", "channeldb")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cdb, err := Open(tempDirName + "/channel.db")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanUp := func() {
+		cdb.Close()
+		os.RemoveAll(tempDirName)
+	}
+
+	return cdb, cleanUp, nil
+}
@@ -0,0 +1,155 @@
+package channeldb
+
+import (
+	"io"
+	"time"
+
+	"github.com/roasbeef/btcutil"
+)
+
+// ContractTerm is a sub-struct of the Invoice struct which contains the
+// fundamental terms of an invoice: the preimage required to settle it, and
+// the amount the payer is expected to send.
+type ContractTerm struct {
+	// PaymentPreimage is the preimage which is to be revealed in the
+	// proof-of-payment.
+	PaymentPreimage [32]byte
+
+	// Value is the expected amount of milli-satoshis to settle the
+	// invoice.
+	Value btcutil.Amount
+}
+
+// Invoice is a payment invoice generated by a payee in order to request
+// payment for some good or service. Invoices are a request for payment, and
+// are therefore dissimilar to OutgoingPayment which represent the outflow
+// of satoshis from the daemon.
+type Invoice struct {
+	// CreationDate is the time the invoice was created.
+	CreationDate time.Time
+
+	// Memo is an optional field used to attach a note to an invoice,
+	// typically containing the reason for the invoice being created.
+	Memo []byte
+
+	// Receipt is an optional field used to attach a receipt to an
+	// invoice, for additional proof-of-payment purposes.
+	Receipt []byte
+
+	// Terms are the fundamental terms of the invoice, namely the amount
+	// and preimage.
+	Terms ContractTerm
+}
+
+// serializeInvoice writes the invoice to the passed writer in a byte
+// format understood by deserializeInvoice.
+func serializeInvoice(w io.Writer, i *Invoice) error {
+	var scratch [8]byte
+
+	// CreationDate is encoded to nanosecond precision so that the
+	// round-tripped value is indistinguishable (in wall-clock terms)
+	// from the original. A zero CreationDate is written as 0 rather
+	// than the (nonsensical, pre-epoch) UnixNano of the zero time.Time.
+	var creationNanos int64
+	if !i.CreationDate.IsZero() {
+		creationNanos = i.CreationDate.UnixNano()
+	}
+	byteOrder.PutUint64(scratch[:], uint64(creationNanos))
+	if _, err := w.Write(scratch[:]); err != nil {
+		return err
+	}
+
+	if err := wireWriteByteSlice(w, i.Memo); err != nil {
+		return err
+	}
+	if err := wireWriteByteSlice(w, i.Receipt); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(i.Terms.PaymentPreimage[:]); err != nil {
+		return err
+	}
+
+	byteOrder.PutUint64(scratch[:], uint64(i.Terms.Value))
+	if _, err := w.Write(scratch[:]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// deserializeInvoice reconstructs an Invoice from the format written by
+// serializeInvoice.
+func deserializeInvoice(r io.Reader) (*Invoice, error) {
+	var scratch [8]byte
+
+	invoice := &Invoice{}
+
+	if _, err := io.ReadFull(r, scratch[:]); err != nil {
+		return nil, err
+	}
+	creationNanos := int64(byteOrder.Uint64(scratch[:]))
+	if creationNanos != 0 {
+		invoice.CreationDate = time.Unix(0, creationNanos)
+	}
+
+	memo, err := wireReadByteSlice(r)
+	if err != nil {
+		return nil, err
+	}
+	invoice.Memo = memo
+
+	receipt, err := wireReadByteSlice(r)
+	if err != nil {
+		return nil, err
+	}
+	invoice.Receipt = receipt
+
+	if _, err := io.ReadFull(r, invoice.Terms.PaymentPreimage[:]); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(r, scratch[:]); err != nil {
+		return nil, err
+	}
+	invoice.Terms.Value = btcutil.Amount(byteOrder.Uint64(scratch[:]))
+
+	return invoice, nil
+}
+
+// wireWriteByteSlice writes a length-prefixed byte slice to w.
+func wireWriteByteSlice(w io.Writer, b []byte) error {
+	var lenBuf [4]byte
+	byteOrder.PutUint32(lenBuf[:], uint32(len(b)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+
+	if len(b) == 0 {
+		return nil
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+// wireReadByteSlice reads a length-prefixed byte slice written by
+// wireWriteByteSlice.
+func wireReadByteSlice(r io.Reader) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+
+	l := byteOrder.Uint32(lenBuf[:])
+	if l == 0 {
+		return nil, nil
+	}
+
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
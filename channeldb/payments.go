@@ -0,0 +1,1149 @@
+package channeldb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/roasbeef/btcutil"
+)
+
+var (
+	// paymentBucket is the name of the bucket within the database that
+	// stores all data related to payments.
+	paymentBucket = []byte("payments")
+
+	// paymentHashIndexBucket is the name of the bucket that maps a
+	// payment hash to the sequence key of its record in paymentBucket.
+	// It lets fetchMPPaymentByHash look up a payment directly rather
+	// than scanning every record in the store.
+	paymentHashIndexBucket = []byte("payment-hash-index")
+
+	// ErrNoPaymentsCreated is returned when bucket of payments hasn't
+	// been created.
+	ErrNoPaymentsCreated = errors.New("there are no existing payments")
+
+	// ErrPaymentNotFound is returned when there is no payment record
+	// matching the requested payment hash.
+	ErrPaymentNotFound = errors.New("payment not found")
+)
+
+// paymentVersion identifies the on-disk encoding used for a serialized
+// OutgoingPayment record, allowing the format to evolve while still being
+// able to read records written by older versions of lnd.
+type paymentVersion uint8
+
+const (
+	// paymentVersionLegacy is the original encoding, which has no
+	// concept of payment status, failure reason, or completion time.
+	paymentVersionLegacy paymentVersion = 0
+
+	// paymentVersionStatus adds a trailing, length-prefixed block
+	// carrying Status, FailureReason, and CompletedAt, so that records
+	// written under paymentVersionLegacy can still be read back without
+	// those fields.
+	paymentVersionStatus paymentVersion = 1
+
+	// paymentVersionMPP replaces the single set of path/fee/timelock
+	// fields with a nested list of PaymentAttempt records, allowing a
+	// single payment hash to be split across multiple routes. Records
+	// written under paymentVersionLegacy or paymentVersionStatus are
+	// read back as an MPPayment with exactly one attempt.
+	paymentVersionMPP paymentVersion = 2
+)
+
+// PaymentStatus represents the current state of an outgoing payment attempt
+// as it's persisted across the lifetime of the HTLC(s) it sends out.
+type PaymentStatus byte
+
+const (
+	// StatusInFlight is the status of a payment that has been
+	// initiated, but whose result (success or failure) is not yet
+	// known.
+	StatusInFlight PaymentStatus = iota
+
+	// StatusSucceeded is the status of a payment that has been
+	// confirmed successful by obtaining the payment preimage.
+	StatusSucceeded
+
+	// StatusFailed is the status of a payment that has definitively
+	// failed, and will not be retried.
+	StatusFailed
+)
+
+// String returns a human readable description of the payment status.
+func (ps PaymentStatus) String() string {
+	switch ps {
+	case StatusInFlight:
+		return "In Flight"
+	case StatusSucceeded:
+		return "Succeeded"
+	case StatusFailed:
+		return "Failed"
+	default:
+		return "Unknown"
+	}
+}
+
+// FailureReason encodes the reason an attempted payment failed, allowing
+// callers to distinguish permanent failures from those that may be worth
+// retrying with a different route.
+type FailureReason byte
+
+const (
+	// FailureReasonNone indicates the payment has not failed, or is not
+	// yet known to have failed.
+	FailureReasonNone FailureReason = iota
+
+	// FailureReasonUnknownPaymentHash indicates the destination did not
+	// recognize the payment hash presented.
+	FailureReasonUnknownPaymentHash
+
+	// FailureReasonInsufficientFee indicates the fee offered along the
+	// route was insufficient for one or more hops.
+	FailureReasonInsufficientFee
+
+	// FailureReasonExpiryTooSoon indicates the CLTV expiry of the route
+	// did not leave enough of a safety margin for one or more hops.
+	FailureReasonExpiryTooSoon
+
+	// FailureReasonNoRoute indicates no route to the destination could
+	// be found given the current channel graph and constraints.
+	FailureReasonNoRoute
+)
+
+// String returns a human readable description of the failure reason.
+func (fr FailureReason) String() string {
+	switch fr {
+	case FailureReasonNone:
+		return "none"
+	case FailureReasonUnknownPaymentHash:
+		return "unknown payment hash"
+	case FailureReasonInsufficientFee:
+		return "insufficient fee"
+	case FailureReasonExpiryTooSoon:
+		return "expiry too soon"
+	case FailureReasonNoRoute:
+		return "no route to destination"
+	default:
+		return "unknown failure reason"
+	}
+}
+
+// OutgoingPayment represents a payment from the daemon to a remote node,
+// whether still in flight, succeeded, or failed. Details such as the total
+// fee paid, and the time of the payment are recorded.
+type OutgoingPayment struct {
+	Invoice
+
+	// Fee is the total fee paid for this payment, denoted in
+	// milli-satoshis.
+	Fee btcutil.Amount
+
+	// Path encodes the path the payment took through the network, in
+	// the compressed pubkey format of each hop.
+	Path [][33]byte
+
+	// TimeLockLength is the cumulative time-lock across the entire
+	// route.
+	TimeLockLength uint32
+
+	// PaymentHash is the payment hash this payment was attempting to
+	// settle.
+	PaymentHash [32]byte
+
+	// Status is the current status of the payment, allowing in-flight
+	// attempts to be told apart from terminal (settled or failed) ones.
+	Status PaymentStatus
+
+	// FailureReason is the reason this payment failed, if Status is
+	// StatusFailed. It is the zero value otherwise.
+	FailureReason FailureReason
+
+	// CompletedAt is the time at which the payment reached a terminal
+	// status (StatusSucceeded or StatusFailed). It is the zero value
+	// while the payment is still in flight.
+	CompletedAt time.Time
+}
+
+// PaymentAttempt records a single attempt to fulfil a logical payment over
+// one route. A payment identified by PaymentHash may own several attempts,
+// each splitting off part of the total value, as in a multi-path payment.
+type PaymentAttempt struct {
+	// AttemptID uniquely identifies this attempt among the others
+	// belonging to the same payment hash.
+	AttemptID uint64
+
+	// Path encodes the route this attempt took through the network, in
+	// the compressed pubkey format of each hop.
+	Path [][33]byte
+
+	// Fee is the fee paid to route this attempt, denoted in
+	// milli-satoshis.
+	Fee btcutil.Amount
+
+	// TimeLockLength is the cumulative time-lock across this attempt's
+	// route.
+	TimeLockLength uint32
+
+	// Value is the portion of the total payment value carried by this
+	// attempt.
+	Value btcutil.Amount
+
+	// Status is the current status of this attempt.
+	Status PaymentStatus
+
+	// FailureReason is the reason this attempt failed, if Status is
+	// StatusFailed.
+	FailureReason FailureReason
+
+	// Preimage is the payment preimage revealed by the receiver once
+	// this attempt has settled. It is the zero value until then.
+	Preimage [32]byte
+
+	// CompletedAt is the time at which this attempt reached a terminal
+	// status.
+	CompletedAt time.Time
+}
+
+// MPPayment aggregates every attempt made so far towards fulfilling a
+// single logical payment, identified by PaymentHash. A payment that was
+// never split across multiple routes is represented as an MPPayment with
+// exactly one attempt.
+type MPPayment struct {
+	Invoice
+
+	// PaymentHash is the payment hash this payment is attempting to
+	// settle.
+	PaymentHash [32]byte
+
+	// TotalAmt is the total value this payment intends to deliver,
+	// fixed when the payment's first attempt is registered and tracked
+	// independently of Invoice.Terms.Value. This lets Status() compute
+	// a correct terminal state for payments built up entirely through
+	// RegisterAttempt, which never carry an invoice, and ensures a
+	// failed attempt that is later retried doesn't permanently lower
+	// the amount required for the payment to be considered settled.
+	TotalAmt btcutil.Amount
+
+	// Attempts holds every attempt made so far to fulfil this payment,
+	// in the order they were registered.
+	Attempts []*PaymentAttempt
+}
+
+// TotalValue returns the cumulative value carried by every attempt that has
+// settled so far.
+func (p *MPPayment) TotalValue() btcutil.Amount {
+	var total btcutil.Amount
+	for _, a := range p.Attempts {
+		if a.Status == StatusSucceeded {
+			total += a.Value
+		}
+	}
+
+	return total
+}
+
+// Status computes the aggregate status of the payment from the status of
+// its individual attempts. The payment is StatusSucceeded once settled
+// attempts cover TotalAmt, StatusFailed once every attempt has failed, and
+// StatusInFlight otherwise.
+func (p *MPPayment) Status() PaymentStatus {
+	if len(p.Attempts) == 0 {
+		return StatusInFlight
+	}
+
+	for _, a := range p.Attempts {
+		if a.Status == StatusSucceeded &&
+			p.TotalValue() >= p.TotalAmt {
+
+			return StatusSucceeded
+		}
+	}
+
+	for _, a := range p.Attempts {
+		if a.Status != StatusFailed {
+			return StatusInFlight
+		}
+	}
+
+	return StatusFailed
+}
+
+// AddPayment saves a payment to the database with whatever Status it
+// carries. It is assumed that all payment are sent using unique payment
+// hashes.
+func (db *DB) AddPayment(payment *OutgoingPayment) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		payments, err := tx.CreateBucketIfNotExists(paymentBucket)
+		if err != nil {
+			return err
+		}
+
+		hashIndex, err := tx.CreateBucketIfNotExists(paymentHashIndexBucket)
+		if err != nil {
+			return err
+		}
+
+		var b bytes.Buffer
+		if err := serializeOutgoingPayment(&b, payment); err != nil {
+			return err
+		}
+
+		paymentSeq, err := payments.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		var paymentSeqBytes [8]byte
+		byteOrder.PutUint64(paymentSeqBytes[:], paymentSeq)
+
+		if err := hashIndex.Put(
+			payment.PaymentHash[:], paymentSeqBytes[:],
+		); err != nil {
+			return err
+		}
+
+		return payments.Put(paymentSeqBytes[:], b.Bytes())
+	})
+}
+
+// UpdatePaymentStatus locates the payment with the given payment hash and
+// updates the status and failure reason of its first attempt. If the new
+// status is terminal (StatusSucceeded or StatusFailed), CompletedAt is
+// stamped with the current time. This allows the router to persist an
+// attempt as StatusInFlight before it is known to succeed, and later
+// reconcile it on restart.
+func (db *DB) UpdatePaymentStatus(paymentHash [32]byte, status PaymentStatus,
+	reason FailureReason) error {
+
+	return db.updateMPPayment(paymentHash, func(payment *MPPayment) error {
+		if len(payment.Attempts) == 0 {
+			return fmt.Errorf("payment %x has no attempts to "+
+				"update", paymentHash)
+		}
+
+		attempt := payment.Attempts[0]
+		attempt.Status = status
+		attempt.FailureReason = reason
+		if status == StatusSucceeded || status == StatusFailed {
+			attempt.CompletedAt = time.Now()
+		}
+
+		return nil
+	})
+}
+
+// RegisterAttempt records a new attempt towards fulfilling the payment
+// identified by paymentHash. If no payment with this hash exists yet, one
+// is created with attempt as its sole attempt and totalAmt recorded as its
+// target value; totalAmt is ignored for a payment that already exists, so
+// that a retried attempt doesn't change the amount required for the
+// payment to be considered settled. This lets the router split a payment
+// across several routes, persisting each attempt before it is known to
+// succeed or fail.
+func (db *DB) RegisterAttempt(paymentHash [32]byte, totalAmt btcutil.Amount,
+	attempt *PaymentAttempt) error {
+
+	return db.Update(func(tx *bolt.Tx) error {
+		payments, err := tx.CreateBucketIfNotExists(paymentBucket)
+		if err != nil {
+			return err
+		}
+
+		hashIndex, err := tx.CreateBucketIfNotExists(paymentHashIndexBucket)
+		if err != nil {
+			return err
+		}
+
+		key, payment, err := fetchMPPaymentByHash(tx, paymentHash)
+		if err != nil && err != ErrPaymentNotFound {
+			return err
+		}
+
+		if payment == nil {
+			payment = &MPPayment{
+				PaymentHash: paymentHash,
+				TotalAmt:    totalAmt,
+			}
+
+			seq, err := payments.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			key = make([]byte, 8)
+			byteOrder.PutUint64(key, seq)
+
+			if err := hashIndex.Put(paymentHash[:], key); err != nil {
+				return err
+			}
+		}
+
+		payment.Attempts = append(payment.Attempts, attempt)
+
+		var b bytes.Buffer
+		if err := serializeMPPayment(&b, payment); err != nil {
+			return err
+		}
+
+		return payments.Put(key, b.Bytes())
+	})
+}
+
+// SettleAttempt marks the attempt identified by attemptID, belonging to the
+// payment identified by paymentHash, as succeeded with the given preimage.
+func (db *DB) SettleAttempt(paymentHash [32]byte, attemptID uint64,
+	preimage [32]byte) error {
+
+	return db.updateAttempt(paymentHash, attemptID,
+		func(attempt *PaymentAttempt) {
+			attempt.Status = StatusSucceeded
+			attempt.Preimage = preimage
+			attempt.CompletedAt = time.Now()
+		},
+	)
+}
+
+// FailAttempt marks the attempt identified by attemptID, belonging to the
+// payment identified by paymentHash, as failed with the given reason.
+func (db *DB) FailAttempt(paymentHash [32]byte, attemptID uint64,
+	reason FailureReason) error {
+
+	return db.updateAttempt(paymentHash, attemptID,
+		func(attempt *PaymentAttempt) {
+			attempt.Status = StatusFailed
+			attempt.FailureReason = reason
+			attempt.CompletedAt = time.Now()
+		},
+	)
+}
+
+// updateAttempt locates the attempt identified by (paymentHash, attemptID)
+// and applies update to it before persisting the owning payment.
+func (db *DB) updateAttempt(paymentHash [32]byte, attemptID uint64,
+	update func(*PaymentAttempt)) error {
+
+	return db.updateMPPayment(paymentHash, func(payment *MPPayment) error {
+		for _, attempt := range payment.Attempts {
+			if attempt.AttemptID != attemptID {
+				continue
+			}
+
+			update(attempt)
+			return nil
+		}
+
+		return fmt.Errorf("attempt %v for payment %x not found",
+			attemptID, paymentHash)
+	})
+}
+
+// updateMPPayment locates the payment identified by paymentHash, applies
+// update to it, and persists the result.
+func (db *DB) updateMPPayment(paymentHash [32]byte,
+	update func(*MPPayment) error) error {
+
+	return db.Update(func(tx *bolt.Tx) error {
+		payments := tx.Bucket(paymentBucket)
+		if payments == nil {
+			return ErrNoPaymentsCreated
+		}
+
+		key, payment, err := fetchMPPaymentByHash(tx, paymentHash)
+		if err != nil {
+			return err
+		}
+
+		if err := update(payment); err != nil {
+			return err
+		}
+
+		var b bytes.Buffer
+		if err := serializeMPPayment(&b, payment); err != nil {
+			return err
+		}
+
+		return payments.Put(key, b.Bytes())
+	})
+}
+
+// fetchMPPaymentByHash looks up the record whose payment hash matches
+// paymentHash via paymentHashIndexBucket, returning its key alongside the
+// deserialized payment. ErrPaymentNotFound is returned if no such record
+// exists. This is a single index lookup rather than a scan of every payment
+// in the store.
+func fetchMPPaymentByHash(tx *bolt.Tx,
+	paymentHash [32]byte) ([]byte, *MPPayment, error) {
+
+	payments := tx.Bucket(paymentBucket)
+	if payments == nil {
+		return nil, nil, ErrPaymentNotFound
+	}
+
+	hashIndex := tx.Bucket(paymentHashIndexBucket)
+	if hashIndex == nil {
+		return nil, nil, ErrPaymentNotFound
+	}
+
+	key := hashIndex.Get(paymentHash[:])
+	if key == nil {
+		return nil, nil, ErrPaymentNotFound
+	}
+
+	v := payments.Get(key)
+	if v == nil {
+		return nil, nil, ErrPaymentNotFound
+	}
+
+	payment, err := deserializeMPPayment(bytes.NewReader(v))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append([]byte(nil), key...), payment, nil
+}
+
+// mpPaymentFromOutgoing wraps a legacy, single-route OutgoingPayment as an
+// MPPayment with exactly one attempt, so that it can be written with
+// serializeMPPayment.
+func mpPaymentFromOutgoing(p *OutgoingPayment) *MPPayment {
+	attempt := &PaymentAttempt{
+		Path:           p.Path,
+		Fee:            p.Fee,
+		TimeLockLength: p.TimeLockLength,
+		Value:          p.Invoice.Terms.Value,
+		Status:         p.Status,
+		FailureReason:  p.FailureReason,
+		CompletedAt:    p.CompletedAt,
+	}
+	if p.Status == StatusSucceeded {
+		attempt.Preimage = p.Invoice.Terms.PaymentPreimage
+	}
+
+	return &MPPayment{
+		Invoice:     p.Invoice,
+		PaymentHash: p.PaymentHash,
+		TotalAmt:    p.Invoice.Terms.Value,
+		Attempts:    []*PaymentAttempt{attempt},
+	}
+}
+
+// outgoingFromMPPayment collapses an MPPayment down to the legacy
+// OutgoingPayment view, taking its first attempt as representative. This is
+// only meaningful for payments that were never split across multiple
+// routes.
+func outgoingFromMPPayment(p *MPPayment) *OutgoingPayment {
+	attempt := &PaymentAttempt{}
+	if len(p.Attempts) > 0 {
+		attempt = p.Attempts[0]
+	}
+
+	return &OutgoingPayment{
+		Invoice:        p.Invoice,
+		Fee:            attempt.Fee,
+		Path:           attempt.Path,
+		TimeLockLength: attempt.TimeLockLength,
+		PaymentHash:    p.PaymentHash,
+		Status:         attempt.Status,
+		FailureReason:  attempt.FailureReason,
+		CompletedAt:    attempt.CompletedAt,
+	}
+}
+
+// serializeOutgoingPayment writes an OutgoingPayment to w. It is retained
+// for callers that only ever deal in single-route payments; internally it
+// is encoded as an MPPayment carrying a single attempt.
+func serializeOutgoingPayment(w io.Writer, p *OutgoingPayment) error {
+	// A time.Time carries an internal monotonic reading alongside its
+	// wall-clock value, which only ever comes from time.Now() and
+	// cannot be represented on disk. Strip it here, before p.Invoice is
+	// copied into the wrapping MPPayment, so that the caller's own
+	// CreationDate is left in the same form (wall-clock only) that a
+	// round trip through the database will reconstruct.
+	p.CreationDate = p.CreationDate.Round(0)
+
+	return serializeMPPayment(w, mpPaymentFromOutgoing(p))
+}
+
+// deserializeOutgoingPayment reconstructs an OutgoingPayment from the
+// format written by serializeOutgoingPayment, handling every payment
+// version written by past versions of lnd. Only the first attempt of the
+// underlying payment is represented.
+func deserializeOutgoingPayment(r io.Reader) (*OutgoingPayment, error) {
+	payment, err := deserializeMPPayment(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return outgoingFromMPPayment(payment), nil
+}
+
+// serializeMPPayment writes an MPPayment to w, prefixed with a
+// paymentVersion byte so that deserializeMPPayment is able to correctly
+// interpret payments written by older versions of lnd.
+func serializeMPPayment(w io.Writer, p *MPPayment) error {
+	if _, err := w.Write([]byte{byte(paymentVersionMPP)}); err != nil {
+		return err
+	}
+
+	if err := serializeInvoice(w, &p.Invoice); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(p.PaymentHash[:]); err != nil {
+		return err
+	}
+
+	var scratch [8]byte
+	byteOrder.PutUint64(scratch[:], uint64(p.TotalAmt))
+	if _, err := w.Write(scratch[:]); err != nil {
+		return err
+	}
+
+	if err := wireWriteUint32(w, uint32(len(p.Attempts))); err != nil {
+		return err
+	}
+
+	for _, attempt := range p.Attempts {
+		if err := serializePaymentAttempt(w, attempt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serializePaymentAttempt writes a single PaymentAttempt to w.
+func serializePaymentAttempt(w io.Writer, a *PaymentAttempt) error {
+	var scratch [8]byte
+
+	byteOrder.PutUint64(scratch[:], a.AttemptID)
+	if _, err := w.Write(scratch[:]); err != nil {
+		return err
+	}
+
+	if err := wireWriteUint32(w, uint32(len(a.Path))); err != nil {
+		return err
+	}
+	for _, hop := range a.Path {
+		if _, err := w.Write(hop[:]); err != nil {
+			return err
+		}
+	}
+
+	byteOrder.PutUint64(scratch[:], uint64(a.Fee))
+	if _, err := w.Write(scratch[:]); err != nil {
+		return err
+	}
+
+	byteOrder.PutUint32(scratch[:4], a.TimeLockLength)
+	if _, err := w.Write(scratch[:4]); err != nil {
+		return err
+	}
+
+	byteOrder.PutUint64(scratch[:], uint64(a.Value))
+	if _, err := w.Write(scratch[:]); err != nil {
+		return err
+	}
+
+	if _, err := w.Write([]byte{byte(a.Status)}); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{byte(a.FailureReason)}); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(a.Preimage[:]); err != nil {
+		return err
+	}
+
+	// CompletedAt is encoded to nanosecond precision, consistent with
+	// Invoice.CreationDate, since SettleAttempt/FailAttempt/
+	// UpdatePaymentStatus stamp it with time.Now().
+	var completedNanos int64
+	if !a.CompletedAt.IsZero() {
+		completedNanos = a.CompletedAt.UnixNano()
+	}
+	byteOrder.PutUint64(scratch[:], uint64(completedNanos))
+	if _, err := w.Write(scratch[:]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// deserializePaymentAttempt reconstructs a PaymentAttempt from the format
+// written by serializePaymentAttempt.
+func deserializePaymentAttempt(r io.Reader) (*PaymentAttempt, error) {
+	var scratch [8]byte
+
+	if _, err := io.ReadFull(r, scratch[:]); err != nil {
+		return nil, err
+	}
+	attemptID := byteOrder.Uint64(scratch[:])
+
+	pathLen, err := wireReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+
+	path := make([][33]byte, pathLen)
+	for i := uint32(0); i < pathLen; i++ {
+		if _, err := io.ReadFull(r, path[i][:]); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := io.ReadFull(r, scratch[:]); err != nil {
+		return nil, err
+	}
+	fee := btcutil.Amount(byteOrder.Uint64(scratch[:]))
+
+	if _, err := io.ReadFull(r, scratch[:4]); err != nil {
+		return nil, err
+	}
+	timeLockLength := byteOrder.Uint32(scratch[:4])
+
+	if _, err := io.ReadFull(r, scratch[:]); err != nil {
+		return nil, err
+	}
+	value := btcutil.Amount(byteOrder.Uint64(scratch[:]))
+
+	var statusByte [1]byte
+	if _, err := io.ReadFull(r, statusByte[:]); err != nil {
+		return nil, err
+	}
+
+	var reasonByte [1]byte
+	if _, err := io.ReadFull(r, reasonByte[:]); err != nil {
+		return nil, err
+	}
+
+	var preimage [32]byte
+	if _, err := io.ReadFull(r, preimage[:]); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(r, scratch[:]); err != nil {
+		return nil, err
+	}
+	completedNanos := int64(byteOrder.Uint64(scratch[:]))
+
+	attempt := &PaymentAttempt{
+		AttemptID:      attemptID,
+		Path:           path,
+		Fee:            fee,
+		TimeLockLength: timeLockLength,
+		Value:          value,
+		Status:         PaymentStatus(statusByte[0]),
+		FailureReason:  FailureReason(reasonByte[0]),
+		Preimage:       preimage,
+	}
+	if completedNanos != 0 {
+		attempt.CompletedAt = time.Unix(0, completedNanos)
+	}
+
+	return attempt, nil
+}
+
+// deserializeMPPayment reconstructs an MPPayment from the format written
+// by serializeMPPayment, handling every payment version written by past
+// versions of lnd. Records written under paymentVersionLegacy or
+// paymentVersionStatus are returned as an MPPayment with a single
+// synthesized attempt.
+func deserializeMPPayment(r io.Reader) (*MPPayment, error) {
+	var versionByte [1]byte
+	if _, err := io.ReadFull(r, versionByte[:]); err != nil {
+		return nil, err
+	}
+	version := paymentVersion(versionByte[0])
+
+	invoice, err := deserializeInvoice(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if version < paymentVersionMPP {
+		return deserializeLegacyPayment(r, version, invoice)
+	}
+
+	var paymentHash [32]byte
+	if _, err := io.ReadFull(r, paymentHash[:]); err != nil {
+		return nil, err
+	}
+
+	var scratch [8]byte
+	if _, err := io.ReadFull(r, scratch[:]); err != nil {
+		return nil, err
+	}
+	totalAmt := btcutil.Amount(byteOrder.Uint64(scratch[:]))
+
+	numAttempts, err := wireReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := make([]*PaymentAttempt, numAttempts)
+	for i := uint32(0); i < numAttempts; i++ {
+		attempt, err := deserializePaymentAttempt(r)
+		if err != nil {
+			return nil, err
+		}
+
+		attempts[i] = attempt
+	}
+
+	return &MPPayment{
+		Invoice:     *invoice,
+		PaymentHash: paymentHash,
+		TotalAmt:    totalAmt,
+		Attempts:    attempts,
+	}, nil
+}
+
+// deserializeLegacyPayment reads the remainder of a record written under
+// paymentVersionLegacy or paymentVersionStatus, whose invoice has already
+// been consumed from r, and wraps it in an MPPayment with a single
+// attempt.
+func deserializeLegacyPayment(r io.Reader, version paymentVersion,
+	invoice *Invoice) (*MPPayment, error) {
+
+	var scratch [8]byte
+
+	if _, err := io.ReadFull(r, scratch[:]); err != nil {
+		return nil, err
+	}
+	fee := btcutil.Amount(byteOrder.Uint64(scratch[:]))
+
+	if _, err := io.ReadFull(r, scratch[:4]); err != nil {
+		return nil, err
+	}
+	timeLockLength := byteOrder.Uint32(scratch[:4])
+
+	var paymentHash [32]byte
+	if _, err := io.ReadFull(r, paymentHash[:]); err != nil {
+		return nil, err
+	}
+
+	pathLen, err := wireReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+
+	path := make([][33]byte, pathLen)
+	for i := uint32(0); i < pathLen; i++ {
+		if _, err := io.ReadFull(r, path[i][:]); err != nil {
+			return nil, err
+		}
+	}
+
+	attempt := &PaymentAttempt{
+		Path:           path,
+		Fee:            fee,
+		TimeLockLength: timeLockLength,
+		Value:          invoice.Terms.Value,
+	}
+
+	if version >= paymentVersionStatus {
+		metaLen, err := wireReadUint32(r)
+		if err != nil {
+			return nil, err
+		}
+
+		metaBuf := make([]byte, metaLen)
+		if _, err := io.ReadFull(r, metaBuf); err != nil {
+			return nil, err
+		}
+		meta := bytes.NewReader(metaBuf)
+
+		var statusByte [1]byte
+		if _, err := io.ReadFull(meta, statusByte[:]); err != nil {
+			return nil, err
+		}
+		attempt.Status = PaymentStatus(statusByte[0])
+
+		var reasonByte [1]byte
+		if _, err := io.ReadFull(meta, reasonByte[:]); err != nil {
+			return nil, err
+		}
+		attempt.FailureReason = FailureReason(reasonByte[0])
+
+		// This meta block is the frozen paymentVersionStatus wire
+		// format; unlike the current format's CompletedAt, it is
+		// intentionally second-granular and must stay that way to
+		// correctly decode records written by past versions of lnd.
+		var unixScratch [8]byte
+		if _, err := io.ReadFull(meta, unixScratch[:]); err != nil {
+			return nil, err
+		}
+		completedUnix := int64(byteOrder.Uint64(unixScratch[:]))
+		if completedUnix != 0 {
+			attempt.CompletedAt = time.Unix(completedUnix, 0)
+		}
+
+		if attempt.Status == StatusSucceeded {
+			attempt.Preimage = invoice.Terms.PaymentPreimage
+		}
+	}
+
+	return &MPPayment{
+		Invoice:     *invoice,
+		PaymentHash: paymentHash,
+		TotalAmt:    invoice.Terms.Value,
+		Attempts:    []*PaymentAttempt{attempt},
+	}, nil
+}
+
+// wireWriteUint32 writes v to w using the package's canonical byte order.
+func wireWriteUint32(w io.Writer, v uint32) error {
+	var scratch [4]byte
+	byteOrder.PutUint32(scratch[:], v)
+	_, err := w.Write(scratch[:])
+	return err
+}
+
+// wireReadUint32 reads a uint32 from r using the package's canonical byte
+// order.
+func wireReadUint32(r io.Reader) (uint32, error) {
+	var scratch [4]byte
+	if _, err := io.ReadFull(r, scratch[:]); err != nil {
+		return 0, err
+	}
+	return byteOrder.Uint32(scratch[:]), nil
+}
+
+// FetchAllPayments returns every logical payment in the payment store as
+// an MPPayment aggregating all attempts made towards it, including
+// in-flight attempts that have not yet reached a terminal status. Callers
+// can inspect Status() to distinguish a stuck HTLC that needs to be
+// reconciled on restart from one that has already settled or failed.
+func (db *DB) FetchAllPayments() ([]*MPPayment, error) {
+	var payments []*MPPayment
+
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(paymentBucket)
+		if bucket == nil {
+			return ErrNoPaymentsCreated
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			r := bytes.NewReader(v)
+			payment, err := deserializeMPPayment(r)
+			if err != nil {
+				return err
+			}
+
+			payments = append(payments, payment)
+
+			return nil
+		})
+	})
+	if err != nil && err != ErrNoPaymentsCreated {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+// PaymentQuery defines the parameters for a query to QueryPayments to
+// retrieve a paginated, optionally filtered, set of payments from the
+// store.
+type PaymentQuery struct {
+	// IndexOffset determines the starting point of the query. It is
+	// exclusive, meaning the first payment returned (in the direction
+	// given by Reversed) is the one immediately after this index. An
+	// offset of 0 starts from the beginning of the store in either
+	// direction.
+	IndexOffset uint64
+
+	// MaxPayments is the maximal number of payments to return. A value
+	// of 0 means no limit.
+	MaxPayments uint64
+
+	// Reversed, if set, walks the store from its most recent payment
+	// towards its oldest, rather than the other way around.
+	Reversed bool
+
+	// IncludeIncomplete indicates whether payments that have not yet
+	// reached a terminal status (StatusInFlight) should be included in
+	// the result set.
+	IncludeIncomplete bool
+
+	// TimeStart, if non-zero, excludes payments created before this
+	// time.
+	TimeStart time.Time
+
+	// TimeEnd, if non-zero, excludes payments created after this time.
+	TimeEnd time.Time
+
+	// DestNodeFilter, if non-empty, restricts the result set to
+	// payments whose final route hop matches this compressed public
+	// key.
+	DestNodeFilter []byte
+}
+
+// PaymentSlice is the result of a QueryPayments call.
+type PaymentSlice struct {
+	PaymentQuery
+
+	// Payments is the set of payments matching the query, in the order
+	// determined by PaymentQuery.Reversed.
+	Payments []*MPPayment
+
+	// FirstIndexOffset is the index of the first payment returned.
+	FirstIndexOffset uint64
+
+	// LastIndexOffset is the index of the last payment returned.
+	LastIndexOffset uint64
+}
+
+// QueryPayments retrieves a paginated, optionally filtered, page of
+// payments from the payment store. Pagination is backed by the
+// monotonically increasing sequence number that bolt assigns as the key
+// of each payment record, so seeking to a page costs O(page size) rather
+// than O(total payments).
+func (db *DB) QueryPayments(query PaymentQuery) (PaymentSlice, error) {
+	resp := PaymentSlice{
+		PaymentQuery: query,
+	}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(paymentBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+
+		var seekKey [8]byte
+		byteOrder.PutUint64(seekKey[:], query.IndexOffset)
+
+		var k, v []byte
+		switch {
+		case query.Reversed && query.IndexOffset == 0:
+			k, v = c.Last()
+		case query.Reversed:
+			c.Seek(seekKey[:])
+			k, v = c.Prev()
+		case query.IndexOffset == 0:
+			k, v = c.First()
+		default:
+			c.Seek(seekKey[:])
+			k, v = c.Next()
+		}
+
+		for k != nil {
+			if query.MaxPayments > 0 &&
+				uint64(len(resp.Payments)) >= query.MaxPayments {
+
+				break
+			}
+
+			payment, err := deserializeMPPayment(
+				bytes.NewReader(v),
+			)
+			if err != nil {
+				return err
+			}
+
+			if matchesPaymentQuery(payment, query) {
+				index := byteOrder.Uint64(k)
+				if len(resp.Payments) == 0 {
+					resp.FirstIndexOffset = index
+				}
+				resp.LastIndexOffset = index
+
+				resp.Payments = append(resp.Payments, payment)
+			}
+
+			if query.Reversed {
+				k, v = c.Prev()
+			} else {
+				k, v = c.Next()
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return PaymentSlice{}, err
+	}
+
+	if query.Reversed {
+		reversePayments(resp.Payments)
+		resp.FirstIndexOffset, resp.LastIndexOffset =
+			resp.LastIndexOffset, resp.FirstIndexOffset
+	}
+
+	return resp, nil
+}
+
+// matchesPaymentQuery reports whether payment satisfies the filters set on
+// query.
+func matchesPaymentQuery(payment *MPPayment, query PaymentQuery) bool {
+	if !query.IncludeIncomplete && payment.Status() == StatusInFlight {
+		return false
+	}
+
+	if !query.TimeStart.IsZero() &&
+		payment.CreationDate.Before(query.TimeStart) {
+
+		return false
+	}
+	if !query.TimeEnd.IsZero() &&
+		payment.CreationDate.After(query.TimeEnd) {
+
+		return false
+	}
+
+	if len(query.DestNodeFilter) != 0 {
+		if len(payment.Attempts) == 0 {
+			return false
+		}
+
+		path := payment.Attempts[len(payment.Attempts)-1].Path
+		if len(path) == 0 {
+			return false
+		}
+
+		lastHop := path[len(path)-1]
+		if !bytes.Equal(lastHop[:], query.DestNodeFilter) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reversePayments reverses the order of payments in place.
+func reversePayments(payments []*MPPayment) {
+	for i, j := 0, len(payments)-1; i < j; i, j = i+1, j-1 {
+		payments[i], payments[j] = payments[j], payments[i]
+	}
+}
+
+// DeleteAllPayments deletes all payments from the DB.
+func (db *DB) DeleteAllPayments() error {
+	return db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket(paymentBucket)
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		if _, err := tx.CreateBucket(paymentBucket); err != nil {
+			return err
+		}
+
+		err = tx.DeleteBucket(paymentHashIndexBucket)
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		_, err = tx.CreateBucket(paymentHashIndexBucket)
+		return err
+	})
+}
@@ -117,6 +117,60 @@ func TestOutgoingPaymentSerialization(t *testing.T) {
 	}
 }
 
+// TestOutgoingPaymentStatusSerialization asserts that every combination of
+// PaymentStatus and FailureReason survives a serialize/deserialize
+// round-trip, along with the CompletedAt timestamp that accompanies a
+// terminal status.
+func TestOutgoingPaymentStatusSerialization(t *testing.T) {
+	statuses := []PaymentStatus{
+		StatusInFlight, StatusSucceeded, StatusFailed,
+	}
+	reasons := []FailureReason{
+		FailureReasonNone,
+		FailureReasonUnknownPaymentHash,
+		FailureReasonInsufficientFee,
+		FailureReasonExpiryTooSoon,
+		FailureReasonNoRoute,
+	}
+
+	for _, status := range statuses {
+		for _, reason := range reasons {
+			fakePayment := makeFakePayment()
+			fakePayment.Status = status
+			fakePayment.FailureReason = reason
+
+			if status != StatusInFlight {
+				fakePayment.CompletedAt = time.Unix(
+					fakePayment.CreationDate.Unix()+1, 0,
+				)
+			}
+
+			var b bytes.Buffer
+			if err := serializeOutgoingPayment(&b, fakePayment); err != nil {
+				t.Fatalf("unable to serialize payment: %v", err)
+			}
+
+			newPayment, err := deserializeOutgoingPayment(&b)
+			if err != nil {
+				t.Fatalf("unable to deserialize payment: %v", err)
+			}
+
+			if newPayment.Status != status {
+				t.Fatalf("status mismatch: want %v, got %v",
+					status, newPayment.Status)
+			}
+			if newPayment.FailureReason != reason {
+				t.Fatalf("failure reason mismatch: want %v, "+
+					"got %v", reason, newPayment.FailureReason)
+			}
+			if !newPayment.CompletedAt.Equal(fakePayment.CompletedAt) {
+				t.Fatalf("completedAt mismatch: want %v, got %v",
+					fakePayment.CompletedAt, newPayment.CompletedAt)
+			}
+		}
+	}
+}
+
 func TestOutgoingPaymentWorkflow(t *testing.T) {
 	db, cleanUp, err := makeTestDB()
 	defer cleanUp()
@@ -134,7 +188,7 @@ func TestOutgoingPaymentWorkflow(t *testing.T) {
 		t.Fatalf("unable to fetch payments from DB: %v", err)
 	}
 
-	expectedPayments := []*OutgoingPayment{fakePayment}
+	expectedPayments := []*MPPayment{mpPaymentFromOutgoing(fakePayment)}
 	if !reflect.DeepEqual(payments, expectedPayments) {
 		t.Fatalf("Wrong payments after reading from DB."+
 			"Got %v, want %v",
@@ -154,7 +208,9 @@ func TestOutgoingPaymentWorkflow(t *testing.T) {
 			t.Fatalf("unable to put payment in DB: %v", err)
 		}
 
-		expectedPayments = append(expectedPayments, randomPayment)
+		expectedPayments = append(
+			expectedPayments, mpPaymentFromOutgoing(randomPayment),
+		)
 	}
 
 	payments, err = db.FetchAllPayments()
@@ -185,3 +241,536 @@ func TestOutgoingPaymentWorkflow(t *testing.T) {
 			len(paymentsAfterDeletion), 0)
 	}
 }
+
+// TestQueryPayments asserts that QueryPayments correctly paginates, in both
+// forward and reverse order, honors IndexOffset semantics, and filters by
+// destination node.
+func TestQueryPayments(t *testing.T) {
+	db, cleanUp, err := makeTestDB()
+	defer cleanUp()
+	if err != nil {
+		t.Fatalf("unable to make test db: %v", err)
+	}
+
+	const numPayments = 10
+	payments := make([]*MPPayment, 0, numPayments)
+	for i := 0; i < numPayments; i++ {
+		payment, err := makeRandomFakePayment()
+		if err != nil {
+			t.Fatalf("unable to create payment: %v", err)
+		}
+
+		if err := db.AddPayment(payment); err != nil {
+			t.Fatalf("unable to add payment: %v", err)
+		}
+
+		payments = append(payments, mpPaymentFromOutgoing(payment))
+	}
+
+	// A query with no offset and a limit equal to the number of payments
+	// in the DB should return everything, in insertion order.
+	resp, err := db.QueryPayments(PaymentQuery{
+		MaxPayments:       numPayments,
+		IncludeIncomplete: true,
+	})
+	if err != nil {
+		t.Fatalf("unable to query payments: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Payments, payments) {
+		t.Fatalf("forward query returned wrong payments: got %v, "+
+			"want %v", spew.Sdump(resp.Payments),
+			spew.Sdump(payments))
+	}
+
+	// CreationDate must round-trip with nanosecond precision, not just
+	// to the same second, since QueryPayments is relied on to order and
+	// filter payments by this timestamp.
+	for i, payment := range resp.Payments {
+		want := payments[i].CreationDate
+		if !payment.CreationDate.Equal(want) ||
+			payment.CreationDate.Nanosecond() != want.Nanosecond() {
+
+			t.Fatalf("creation date mismatch at %d: got %v, "+
+				"want %v", i, payment.CreationDate, want)
+		}
+	}
+
+	// Requesting a page of 3, offset by the first page's last index,
+	// should return the next 3 payments.
+	const pageSize = 3
+	firstPage, err := db.QueryPayments(PaymentQuery{
+		MaxPayments:       pageSize,
+		IncludeIncomplete: true,
+	})
+	if err != nil {
+		t.Fatalf("unable to query payments: %v", err)
+	}
+	if !reflect.DeepEqual(firstPage.Payments, payments[:pageSize]) {
+		t.Fatalf("unexpected first page: %v", spew.Sdump(firstPage))
+	}
+
+	secondPage, err := db.QueryPayments(PaymentQuery{
+		IndexOffset:       firstPage.LastIndexOffset,
+		MaxPayments:       pageSize,
+		IncludeIncomplete: true,
+	})
+	if err != nil {
+		t.Fatalf("unable to query payments: %v", err)
+	}
+	if !reflect.DeepEqual(
+		secondPage.Payments, payments[pageSize:2*pageSize],
+	) {
+		t.Fatalf("unexpected second page: %v", spew.Sdump(secondPage))
+	}
+
+	// Querying in reverse starting from the end should return the last
+	// pageSize payments, in their original order.
+	lastPage, err := db.QueryPayments(PaymentQuery{
+		MaxPayments:       pageSize,
+		Reversed:          true,
+		IncludeIncomplete: true,
+	})
+	if err != nil {
+		t.Fatalf("unable to query payments: %v", err)
+	}
+	want := payments[numPayments-pageSize:]
+	if !reflect.DeepEqual(lastPage.Payments, want) {
+		t.Fatalf("unexpected reversed page: got %v, want %v",
+			spew.Sdump(lastPage.Payments), spew.Sdump(want))
+	}
+
+	// Filtering by the destination of the first payment's last hop
+	// should return only that payment.
+	firstPath := payments[0].Attempts[0].Path
+	destPubKey := firstPath[len(firstPath)-1]
+	filtered, err := db.QueryPayments(PaymentQuery{
+		MaxPayments:       numPayments,
+		IncludeIncomplete: true,
+		DestNodeFilter:    destPubKey[:],
+	})
+	if err != nil {
+		t.Fatalf("unable to query payments: %v", err)
+	}
+	if len(filtered.Payments) != 1 ||
+		!reflect.DeepEqual(filtered.Payments[0], payments[0]) {
+
+		t.Fatalf("unexpected destination filtered result: %v",
+			spew.Sdump(filtered.Payments))
+	}
+}
+
+// makeFakeAttempt builds an in-flight PaymentAttempt carrying a fake,
+// randomly generated route of 1 to 5 hops.
+func makeFakeAttempt(attemptID uint64, value btcutil.Amount) (*PaymentAttempt, error) {
+	fakePathLen := 1 + rand.Intn(5)
+	fakePath := make([][33]byte, fakePathLen)
+	for i := 0; i < fakePathLen; i++ {
+		b, err := randomBytes(33, 34)
+		if err != nil {
+			return nil, err
+		}
+		copy(fakePath[i][:], b)
+	}
+
+	return &PaymentAttempt{
+		AttemptID:      attemptID,
+		Path:           fakePath,
+		Fee:            btcutil.Amount(rand.Intn(1001)),
+		TimeLockLength: uint32(rand.Intn(10000)),
+		Value:          value,
+		Status:         StatusInFlight,
+	}, nil
+}
+
+// TestMultiPathPayment asserts that a single payment hash can accumulate
+// several attempts via RegisterAttempt, and that settling and failing
+// individual attempts is correctly reflected in the payment's aggregate
+// TotalValue and Status.
+func TestMultiPathPayment(t *testing.T) {
+	db, cleanUp, err := makeTestDB()
+	defer cleanUp()
+	if err != nil {
+		t.Fatalf("unable to make test db: %v", err)
+	}
+
+	const totalValue = btcutil.Amount(30000)
+	const splitValue = totalValue / 3
+
+	var paymentHash [32]byte
+	copy(paymentHash[:], bytes.Repeat([]byte{0xaa}, 32))
+
+	attempts := make([]*PaymentAttempt, 3)
+	for i := range attempts {
+		attempt, err := makeFakeAttempt(uint64(i), splitValue)
+		if err != nil {
+			t.Fatalf("unable to create attempt: %v", err)
+		}
+		attempts[i] = attempt
+
+		if err := db.RegisterAttempt(
+			paymentHash, totalValue, attempt,
+		); err != nil {
+			t.Fatalf("unable to register attempt: %v", err)
+		}
+	}
+
+	payments, err := db.FetchAllPayments()
+	if err != nil {
+		t.Fatalf("unable to fetch payments: %v", err)
+	}
+	if len(payments) != 1 {
+		t.Fatalf("expected 1 payment, got %v", len(payments))
+	}
+	payment := payments[0]
+	if len(payment.Attempts) != len(attempts) {
+		t.Fatalf("expected %v attempts, got %v", len(attempts),
+			len(payment.Attempts))
+	}
+	if payment.Status() != StatusInFlight {
+		t.Fatalf("expected payment to be in flight, got %v",
+			payment.Status())
+	}
+
+	// Fail the first attempt: the payment as a whole should remain in
+	// flight since the other two attempts are still outstanding.
+	if err := db.FailAttempt(
+		paymentHash, 0, FailureReasonNoRoute,
+	); err != nil {
+		t.Fatalf("unable to fail attempt: %v", err)
+	}
+
+	// Settle the remaining two attempts with a preimage each.
+	var preimage [32]byte
+	copy(preimage[:], bytes.Repeat([]byte{0xbb}, 32))
+	if err := db.SettleAttempt(paymentHash, 1, preimage); err != nil {
+		t.Fatalf("unable to settle attempt: %v", err)
+	}
+	if err := db.SettleAttempt(paymentHash, 2, preimage); err != nil {
+		t.Fatalf("unable to settle attempt: %v", err)
+	}
+
+	payments, err = db.FetchAllPayments()
+	if err != nil {
+		t.Fatalf("unable to fetch payments: %v", err)
+	}
+	payment = payments[0]
+
+	wantValue := 2 * splitValue
+	if payment.TotalValue() != wantValue {
+		t.Fatalf("expected total settled value %v, got %v",
+			wantValue, payment.TotalValue())
+	}
+
+	// One attempt failed but two succeeded, so the payment as a whole
+	// is still in flight rather than fully failed or fully succeeded.
+	if payment.Status() != StatusInFlight {
+		t.Fatalf("expected payment to still be in flight, got %v",
+			payment.Status())
+	}
+
+	// A second, independent payment hash whose every attempt fails
+	// should be reported as terminally failed.
+	var otherHash [32]byte
+	copy(otherHash[:], bytes.Repeat([]byte{0xcc}, 32))
+
+	for i := 0; i < 2; i++ {
+		attempt, err := makeFakeAttempt(uint64(i), splitValue)
+		if err != nil {
+			t.Fatalf("unable to create attempt: %v", err)
+		}
+
+		if err := db.RegisterAttempt(
+			otherHash, 2*splitValue, attempt,
+		); err != nil {
+			t.Fatalf("unable to register attempt: %v", err)
+		}
+		if err := db.FailAttempt(
+			otherHash, uint64(i), FailureReasonNoRoute,
+		); err != nil {
+			t.Fatalf("unable to fail attempt: %v", err)
+		}
+	}
+
+	payments, err = db.FetchAllPayments()
+	if err != nil {
+		t.Fatalf("unable to fetch payments: %v", err)
+	}
+
+	var otherPayment *MPPayment
+	for _, p := range payments {
+		if p.PaymentHash == otherHash {
+			otherPayment = p
+		}
+	}
+	if otherPayment == nil {
+		t.Fatalf("second payment not found")
+	}
+	if otherPayment.Status() != StatusFailed {
+		t.Fatalf("expected second payment to be failed, got %v",
+			otherPayment.Status())
+	}
+
+	// A third, independent payment hash whose every attempt succeeds
+	// should be reported as terminally succeeded, even though it was
+	// built up entirely through RegisterAttempt and so never carries an
+	// invoice.
+	var succeededHash [32]byte
+	copy(succeededHash[:], bytes.Repeat([]byte{0xdd}, 32))
+
+	for i := 0; i < 3; i++ {
+		attempt, err := makeFakeAttempt(uint64(i), splitValue)
+		if err != nil {
+			t.Fatalf("unable to create attempt: %v", err)
+		}
+
+		if err := db.RegisterAttempt(
+			succeededHash, totalValue, attempt,
+		); err != nil {
+			t.Fatalf("unable to register attempt: %v", err)
+		}
+		if err := db.SettleAttempt(
+			succeededHash, uint64(i), preimage,
+		); err != nil {
+			t.Fatalf("unable to settle attempt: %v", err)
+		}
+	}
+
+	payments, err = db.FetchAllPayments()
+	if err != nil {
+		t.Fatalf("unable to fetch payments: %v", err)
+	}
+
+	var succeededPayment *MPPayment
+	for _, p := range payments {
+		if p.PaymentHash == succeededHash {
+			succeededPayment = p
+		}
+	}
+	if succeededPayment == nil {
+		t.Fatalf("third payment not found")
+	}
+	if succeededPayment.Status() != StatusSucceeded {
+		t.Fatalf("expected third payment to be succeeded, got %v",
+			succeededPayment.Status())
+	}
+
+	// A fourth, independent payment hash whose first attempt fails and
+	// is then retried for the full amount should be reported as
+	// terminally succeeded once the retry settles. This is the ordinary
+	// single-route "first route failed, retry succeeded" case, not an
+	// MPP split, and must converge even though TotalValue() only counts
+	// the settled retry, not the earlier failed attempt.
+	var retriedHash [32]byte
+	copy(retriedHash[:], bytes.Repeat([]byte{0xee}, 32))
+
+	firstAttempt, err := makeFakeAttempt(0, totalValue)
+	if err != nil {
+		t.Fatalf("unable to create attempt: %v", err)
+	}
+	if err := db.RegisterAttempt(
+		retriedHash, totalValue, firstAttempt,
+	); err != nil {
+		t.Fatalf("unable to register attempt: %v", err)
+	}
+	if err := db.FailAttempt(
+		retriedHash, 0, FailureReasonNoRoute,
+	); err != nil {
+		t.Fatalf("unable to fail attempt: %v", err)
+	}
+
+	retryAttempt, err := makeFakeAttempt(1, totalValue)
+	if err != nil {
+		t.Fatalf("unable to create attempt: %v", err)
+	}
+	if err := db.RegisterAttempt(
+		retriedHash, totalValue, retryAttempt,
+	); err != nil {
+		t.Fatalf("unable to register attempt: %v", err)
+	}
+	if err := db.SettleAttempt(retriedHash, 1, preimage); err != nil {
+		t.Fatalf("unable to settle attempt: %v", err)
+	}
+
+	payments, err = db.FetchAllPayments()
+	if err != nil {
+		t.Fatalf("unable to fetch payments: %v", err)
+	}
+
+	var retriedPayment *MPPayment
+	for _, p := range payments {
+		if p.PaymentHash == retriedHash {
+			retriedPayment = p
+		}
+	}
+	if retriedPayment == nil {
+		t.Fatalf("fourth payment not found")
+	}
+	if retriedPayment.Status() != StatusSucceeded {
+		t.Fatalf("expected retried payment to be succeeded, got %v",
+			retriedPayment.Status())
+	}
+}
+
+// buildLegacyPaymentRecord hand-encodes a record in the wire format used by
+// paymentVersionLegacy and paymentVersionStatus, i.e. the single-route,
+// non-MPP format written by versions of lnd that predate PaymentAttempt.
+func buildLegacyPaymentRecord(t *testing.T, version paymentVersion,
+	invoice *Invoice, fee btcutil.Amount, timeLockLength uint32,
+	paymentHash [32]byte, path [][33]byte, status PaymentStatus,
+	reason FailureReason, completedAt time.Time) []byte {
+
+	var b bytes.Buffer
+
+	b.WriteByte(byte(version))
+
+	if err := serializeInvoice(&b, invoice); err != nil {
+		t.Fatalf("unable to serialize invoice: %v", err)
+	}
+
+	var scratch [8]byte
+	byteOrder.PutUint64(scratch[:], uint64(fee))
+	b.Write(scratch[:])
+
+	byteOrder.PutUint32(scratch[:4], timeLockLength)
+	b.Write(scratch[:4])
+
+	b.Write(paymentHash[:])
+
+	if err := wireWriteUint32(&b, uint32(len(path))); err != nil {
+		t.Fatalf("unable to write path length: %v", err)
+	}
+	for _, hop := range path {
+		b.Write(hop[:])
+	}
+
+	if version >= paymentVersionStatus {
+		var meta bytes.Buffer
+		meta.WriteByte(byte(status))
+		meta.WriteByte(byte(reason))
+
+		var completedUnix int64
+		if !completedAt.IsZero() {
+			completedUnix = completedAt.Unix()
+		}
+		byteOrder.PutUint64(scratch[:], uint64(completedUnix))
+		meta.Write(scratch[:])
+
+		if err := wireWriteUint32(&b, uint32(meta.Len())); err != nil {
+			t.Fatalf("unable to write meta length: %v", err)
+		}
+		b.Write(meta.Bytes())
+	}
+
+	return b.Bytes()
+}
+
+// TestDeserializeLegacyPayment asserts that deserializeMPPayment correctly
+// decodes records written under paymentVersionLegacy and
+// paymentVersionStatus, the pre-MPP, single-attempt wire formats that real
+// records on disk may still be encoded in.
+func TestDeserializeLegacyPayment(t *testing.T) {
+	invoice := &Invoice{
+		CreationDate: time.Now(),
+		Memo:         []byte("legacy memo"),
+		Receipt:      []byte("legacy receipt"),
+	}
+	copy(invoice.Terms.PaymentPreimage[:], rev[:])
+	invoice.Terms.Value = btcutil.Amount(5000)
+
+	fakePath := make([][33]byte, 2)
+	for i := range fakePath {
+		copy(fakePath[i][:], bytes.Repeat([]byte{byte(i + 10)}, 33))
+	}
+
+	var paymentHash [32]byte
+	copy(paymentHash[:], bytes.Repeat([]byte{0x42}, 32))
+
+	// A paymentVersionLegacy record carries no status information, so it
+	// should come back as an MPPayment with a single, implicitly
+	// in-flight attempt.
+	v0 := buildLegacyPaymentRecord(
+		t, paymentVersionLegacy, invoice, 99, 144, paymentHash,
+		fakePath, StatusInFlight, FailureReasonNone, time.Time{},
+	)
+
+	payment, err := deserializeMPPayment(bytes.NewReader(v0))
+	if err != nil {
+		t.Fatalf("unable to deserialize v0 payment: %v", err)
+	}
+	if payment.PaymentHash != paymentHash {
+		t.Fatalf("payment hash mismatch")
+	}
+	if len(payment.Attempts) != 1 {
+		t.Fatalf("expected 1 attempt, got %v", len(payment.Attempts))
+	}
+
+	attempt := payment.Attempts[0]
+	if attempt.Fee != 99 {
+		t.Fatalf("fee mismatch: got %v, want %v", attempt.Fee, 99)
+	}
+	if attempt.TimeLockLength != 144 {
+		t.Fatalf("time lock mismatch: got %v, want %v",
+			attempt.TimeLockLength, 144)
+	}
+	if attempt.Value != invoice.Terms.Value {
+		t.Fatalf("value mismatch: got %v, want %v", attempt.Value,
+			invoice.Terms.Value)
+	}
+	if !reflect.DeepEqual(attempt.Path, fakePath) {
+		t.Fatalf("path mismatch: got %v, want %v", attempt.Path,
+			fakePath)
+	}
+	if attempt.Status != StatusInFlight {
+		t.Fatalf("status mismatch: got %v, want %v", attempt.Status,
+			StatusInFlight)
+	}
+	if attempt.FailureReason != FailureReasonNone {
+		t.Fatalf("failure reason mismatch: got %v, want %v",
+			attempt.FailureReason, FailureReasonNone)
+	}
+
+	// A paymentVersionStatus record carries status, failure reason, and
+	// completion time, which should all be preserved.
+	completedAt := time.Unix(invoice.CreationDate.Unix()+1, 0)
+	v1 := buildLegacyPaymentRecord(
+		t, paymentVersionStatus, invoice, 99, 144, paymentHash,
+		fakePath, StatusFailed, FailureReasonNoRoute, completedAt,
+	)
+
+	payment, err = deserializeMPPayment(bytes.NewReader(v1))
+	if err != nil {
+		t.Fatalf("unable to deserialize v1 payment: %v", err)
+	}
+
+	attempt = payment.Attempts[0]
+	if attempt.Status != StatusFailed {
+		t.Fatalf("status mismatch: got %v, want %v", attempt.Status,
+			StatusFailed)
+	}
+	if attempt.FailureReason != FailureReasonNoRoute {
+		t.Fatalf("failure reason mismatch: got %v, want %v",
+			attempt.FailureReason, FailureReasonNoRoute)
+	}
+	if !attempt.CompletedAt.Equal(completedAt) {
+		t.Fatalf("completedAt mismatch: got %v, want %v",
+			attempt.CompletedAt, completedAt)
+	}
+
+	// A succeeded legacy record should have the invoice's preimage
+	// carried over onto the synthesized attempt.
+	v1Succeeded := buildLegacyPaymentRecord(
+		t, paymentVersionStatus, invoice, 99, 144, paymentHash,
+		fakePath, StatusSucceeded, FailureReasonNone, completedAt,
+	)
+
+	payment, err = deserializeMPPayment(bytes.NewReader(v1Succeeded))
+	if err != nil {
+		t.Fatalf("unable to deserialize succeeded v1 payment: %v", err)
+	}
+
+	attempt = payment.Attempts[0]
+	if attempt.Preimage != invoice.Terms.PaymentPreimage {
+		t.Fatalf("preimage mismatch: got %x, want %x",
+			attempt.Preimage, invoice.Terms.PaymentPreimage)
+	}
+}